@@ -16,6 +16,8 @@ limitations under the License.
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -25,8 +27,10 @@ import (
 	"strings"
 	"syscall"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/kubernetes-csi/csi-test/v4/driver"
 	"github.com/kubernetes-csi/csi-test/v4/mock/service"
+	"google.golang.org/grpc/credentials"
 	"gopkg.in/yaml.v2"
 	"k8s.io/klog/v2"
 )
@@ -50,6 +54,19 @@ func main() {
 	flag.BoolVar(&config.DisableOnlineExpansion, "disable-online-expansion", false, "Disables online volume expansion capability.")
 	flag.BoolVar(&config.PermissiveTargetPath, "permissive-target-path", false, "Allows the CO to create PublishVolumeRequest.TargetPath, which violates the CSI spec.")
 	flag.StringVar(&hooksFile, "hooks-file", "", "YAML file with hook scripts.")
+	var watchHooksFile bool
+	flag.BoolVar(&watchHooksFile, "watch-hooks-file", false, "Watch -hooks-file for changes (via fsnotify) and reload it in place, in addition to reloading on SIGHUP.")
+	var tlsCert, tlsKey, tlsClientCA, tlsMinVersion string
+	flag.StringVar(&tlsCert, "tls-cert", "", "Path to a PEM-encoded certificate used to terminate TLS at the gRPC layer, for both tcp:// and unix:// endpoints.")
+	flag.StringVar(&tlsKey, "tls-key", "", "Path to the PEM-encoded private key matching -tls-cert.")
+	flag.StringVar(&tlsClientCA, "tls-client-ca", "", "Path to a PEM-encoded CA bundle used to verify client certificates (mTLS). If unset, client certificates are not required.")
+	flag.StringVar(&tlsMinVersion, "tls-min-version", "1.2", "Minimum TLS version to accept: 1.0, 1.1, 1.2 or 1.3.")
+	var traceFile, otlpEndpoint string
+	flag.StringVar(&traceFile, "trace-file", "", "If set, append a JSON-lines trace entry for every RPC to this file.")
+	flag.StringVar(&otlpEndpoint, "otlp-endpoint", "", "If set, export a trace entry for every RPC to this OTLP/gRPC log collector address.")
+	var maxConcurrentStreams uint
+	flag.UintVar(&maxConcurrentStreams, "max-concurrent-streams", 0, "Maximum number of concurrent RPCs the gRPC server will process. 0 uses the grpc-go default.")
+	flag.StringVar(&config.StateDir, "state-dir", "", "If set, persist volume state as JSON under this directory so it survives a driver restart. Snapshots are not persisted.")
 	flag.Parse()
 
 	endpoint := os.Getenv("CSI_ENDPOINT")
@@ -68,22 +85,43 @@ func main() {
 		}
 	}
 
+	tlsConfig, err := buildTLSConfig(tlsCert, tlsKey, tlsClientCA, tlsMinVersion)
+	if err != nil {
+		klog.Exitf("Error: Invalid TLS configuration: %v\n", err)
+	}
+
+	tracer, err := buildTracer(traceFile, otlpEndpoint)
+	if err != nil {
+		klog.Exitf("Error: Unable to set up RPC tracing: %v\n", err)
+	}
+	if tracer != nil {
+		config.Tracer = tracer
+		defer tracer.Close()
+	}
+
+	var driverOpts []driver.Option
+	if maxConcurrentStreams > 0 {
+		driverOpts = append(driverOpts, driver.WithMaxConcurrentStreams(uint32(maxConcurrentStreams)))
+	}
+	if tlsConfig != nil {
+		driverOpts = append(driverOpts, driver.WithCreds(credentials.NewTLS(tlsConfig)))
+	}
+
 	// Create mock driver
 	s := service.New(config)
 
+	if hooksFile != "" {
+		stopReloader := installHooksReloader(hooksFile, watchHooksFile, s)
+		defer stopReloader()
+	}
+
 	if endpoint == controllerEndpoint {
 		servers := &driver.CSIDriverServers{
 			Controller: s,
 			Identity:   s,
 			Node:       s,
 		}
-		d := driver.NewCSIDriver(servers)
-
-		// If creds is enabled, set the default creds.
-		setCreds := os.Getenv("CSI_ENABLE_CREDS")
-		if len(setCreds) > 0 && setCreds == "true" {
-			d.SetDefaultCreds()
-		}
+		d := driver.NewCSIDriver(servers, driverOpts...)
 
 		// Listen
 		l, cleanup, err := listen(endpoint)
@@ -105,7 +143,6 @@ func main() {
 		sigc := make(chan os.Signal, 1)
 		sigs := []os.Signal{
 			syscall.SIGTERM,
-			syscall.SIGHUP,
 			syscall.SIGINT,
 			syscall.SIGQUIT,
 		}
@@ -119,19 +156,13 @@ func main() {
 			Controller: s,
 			Identity:   s,
 		}
-		dc := driver.NewCSIDriverController(controllerServer)
+		dc := driver.NewCSIDriverController(controllerServer, driverOpts...)
 
 		nodeServer := &driver.CSIDriverNodeServer{
 			Node:     s,
 			Identity: s,
 		}
-		dn := driver.NewCSIDriverNode(nodeServer)
-
-		setCreds := os.Getenv("CSI_ENABLE_CREDS")
-		if len(setCreds) > 0 && setCreds == "true" {
-			dc.SetDefaultCreds()
-			dn.SetDefaultCreds()
-		}
+		dn := driver.NewCSIDriverNode(nodeServer, driverOpts...)
 
 		// Listen controller.
 		l, cleanupController, err := listen(controllerEndpoint)
@@ -169,7 +200,6 @@ func main() {
 		sigc := make(chan os.Signal, 1)
 		sigs := []os.Signal{
 			syscall.SIGTERM,
-			syscall.SIGHUP,
 			syscall.SIGINT,
 			syscall.SIGQUIT,
 		}
@@ -212,9 +242,184 @@ func listen(endpoint string) (net.Listener, func(), error) {
 	}
 
 	l, err := net.Listen(proto, addr)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	return l, cleanup, err
 }
 
+// buildTLSConfig assembles a *tls.Config for credentials.NewTLS, used to
+// terminate TLS at the gRPC layer (see driver.WithCreds), from the -tls-*
+// flags. It returns a nil config (no error) when -tls-cert is unset, which
+// leaves the endpoint running in plaintext exactly as before this flag was
+// introduced.
+func buildTLSConfig(certFile, keyFile, clientCAFile, minVersion string) (*tls.Config, error) {
+	if certFile == "" {
+		return nil, nil
+	}
+	if keyFile == "" {
+		return nil, fmt.Errorf("-tls-key is required when -tls-cert is set")
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS key pair: %v", err)
+	}
+
+	version, err := parseTLSVersion(minVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   version,
+		// ALPN-negotiate h2: required for gRPC clients that enforce HTTP/2
+		// over TLS, which credentials.NewTLS does not set on its own.
+		NextProtos: []string{"h2"},
+	}
+
+	if clientCAFile != "" {
+		caBytes, err := ioutil.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read -tls-client-ca: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caBytes) {
+			return nil, fmt.Errorf("no certificates found in -tls-client-ca %s", clientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tlsConfig, nil
+}
+
+// buildTracer wires -trace-file and -otlp-endpoint into a service.Tracer.
+// The two sinks are independent and either or both may be enabled at once.
+func buildTracer(traceFile, otlpEndpoint string) (service.Tracer, error) {
+	var tracers []service.Tracer
+
+	if traceFile != "" {
+		t, err := service.NewFileTracer(traceFile)
+		if err != nil {
+			return nil, err
+		}
+		tracers = append(tracers, t)
+	}
+
+	if otlpEndpoint != "" {
+		t, err := service.NewOTLPTracer(otlpEndpoint)
+		if err != nil {
+			return nil, err
+		}
+		tracers = append(tracers, t)
+	}
+
+	switch len(tracers) {
+	case 0:
+		return nil, nil
+	case 1:
+		return tracers[0], nil
+	default:
+		return service.MultiTracer(tracers), nil
+	}
+}
+
+func parseTLSVersion(version string) (uint16, error) {
+	switch version {
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unsupported -tls-min-version %q: must be one of 1.0, 1.1, 1.2, 1.3", version)
+	}
+}
+
+// installHooksReloader arranges for hooksFile to be reparsed and swapped
+// into s, without restarting the driver, whenever the process receives
+// SIGHUP and, if watch is set, whenever fsnotify reports the file changed.
+// A reload that fails to parse is logged and ignored, leaving the
+// previously active hooks in place. The returned func stops the reloader.
+func installHooksReloader(hooksFile string, watch bool, s *service.Service) func() {
+	reload := func() {
+		hooks, err := parseHooksFile(hooksFile)
+		if err != nil {
+			klog.Errorf("Not reloading hooks file %s: %v", hooksFile, err)
+			return
+		}
+		s.SetHooks(hooks)
+		klog.Infof("Reloaded hooks file %s", hooksFile)
+	}
+
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-sighup:
+				reload()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	stop := func() {
+		signal.Stop(sighup)
+		close(done)
+	}
+
+	if !watch {
+		return stop
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		klog.Errorf("Not watching hooks file %s: %v", hooksFile, err)
+		return stop
+	}
+	if err := watcher.Add(hooksFile); err != nil {
+		klog.Errorf("Not watching hooks file %s: %v", hooksFile, err)
+		watcher.Close()
+		return stop
+	}
+
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Op&(fsnotify.Write|fsnotify.Create) != 0 {
+					reload()
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				klog.Errorf("Error watching hooks file %s: %v", hooksFile, err)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		stop()
+		watcher.Close()
+	}
+}
+
 func parseHooksFile(file string) (*service.Hooks, error) {
 	var hooks service.Hooks
 
@@ -0,0 +1,69 @@
+/*
+Copyright 2017 Kubernetes Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"net"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc"
+)
+
+// CSIDriverControllerServer bundles the Controller and Identity services
+// served by a controller-only endpoint.
+type CSIDriverControllerServer struct {
+	Controller csi.ControllerServer
+	Identity   csi.IdentityServer
+}
+
+// CSIDriverController serves the Controller and Identity CSI services,
+// as used when a plugin's controller and node run as separate processes.
+type CSIDriverController struct {
+	servers CSIDriverControllerServer
+	server  *grpc.Server
+}
+
+// NewCSIDriverController creates a CSIDriverController ready to Start. See
+// NewCSIDriver for how opts affects server construction.
+func NewCSIDriverController(servers *CSIDriverControllerServer, opts ...Option) *CSIDriverController {
+	return &CSIDriverController{
+		servers: *servers,
+		server:  newGRPCServer(opts...),
+	}
+}
+
+// Start registers the Controller and Identity services on l and begins
+// serving in a background goroutine.
+func (c *CSIDriverController) Start(l net.Listener) error {
+	csi.RegisterIdentityServer(c.server, c.servers.Identity)
+	csi.RegisterControllerServer(c.server, c.servers.Controller)
+
+	go func() {
+		_ = c.server.Serve(l)
+	}()
+	return nil
+}
+
+// Stop gracefully stops the server, waiting for in-flight RPCs to finish.
+func (c *CSIDriverController) Stop() {
+	c.server.GracefulStop()
+}
+
+// ForceStop immediately stops the server, without waiting for in-flight RPCs.
+func (c *CSIDriverController) ForceStop() {
+	c.server.Stop()
+}
@@ -0,0 +1,173 @@
+/*
+Copyright 2017 Kubernetes Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package driver hosts a CSI RPCs over gRPC, wiring caller-supplied
+// Controller/Identity/Node implementations (such as mock/service.Service)
+// into a *grpc.Server and driving its lifecycle.
+package driver
+
+import (
+	"net"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
+)
+
+// CSIDriverServers bundles the three CSI service implementations that back
+// a driver. All three are commonly the same object (e.g. mock/service.New),
+// but nothing requires that.
+type CSIDriverServers struct {
+	Controller csi.ControllerServer
+	Identity   csi.IdentityServer
+	Node       csi.NodeServer
+}
+
+// Options configures the *grpc.Server a CSIDriver, CSIDriverController or
+// CSIDriverNode serves on. The zero value is a plain grpc.NewServer() with
+// no interceptors, matching the driver's historical behavior.
+type Options struct {
+	// Server, if set, is used as-is instead of building one from the other
+	// fields below. This lets a caller fully control server construction
+	// (TLS creds, custom codecs, ...) while still getting CSIDriver's
+	// lifecycle management.
+	Server *grpc.Server
+
+	// Creds, if set, is used as the server's transport credentials, e.g.
+	// credentials.NewTLS(tlsConfig) to terminate TLS (and, with a
+	// tls.Config.ClientCAs, mTLS) at the gRPC layer. Ignored if WithServer
+	// is also used.
+	Creds credentials.TransportCredentials
+
+	UnaryInterceptors    []grpc.UnaryServerInterceptor
+	StreamInterceptors   []grpc.StreamServerInterceptor
+	KeepaliveParams      *keepalive.ServerParameters
+	MaxRecvMsgSize       int
+	MaxConcurrentStreams uint32
+}
+
+// Option configures an Options via the functional options pattern.
+type Option func(*Options)
+
+// WithServer makes the driver serve on an already-constructed *grpc.Server
+// instead of building its own. Every other Option is ignored once this is
+// set, since the caller owns server construction.
+func WithServer(server *grpc.Server) Option {
+	return func(o *Options) {
+		o.Server = server
+	}
+}
+
+// WithCreds terminates TLS (and, if creds was built with client CAs, mTLS)
+// at the gRPC layer instead of leaving the endpoint in plaintext. Ignored if
+// WithServer is also used.
+func WithCreds(creds credentials.TransportCredentials) Option {
+	return func(o *Options) {
+		o.Creds = creds
+	}
+}
+
+// WithUnaryInterceptors chains interceptors (e.g. for logging, metrics or
+// auth) in front of every unary CSI RPC. Ignored if WithServer is also used.
+func WithUnaryInterceptors(interceptors ...grpc.UnaryServerInterceptor) Option {
+	return func(o *Options) {
+		o.UnaryInterceptors = append(o.UnaryInterceptors, interceptors...)
+	}
+}
+
+// WithMaxConcurrentStreams bounds the number of concurrent RPCs the server
+// will process, matching grpc.MaxConcurrentStreams. Ignored if WithServer
+// is also used.
+func WithMaxConcurrentStreams(n uint32) Option {
+	return func(o *Options) {
+		o.MaxConcurrentStreams = n
+	}
+}
+
+func newGRPCServer(opts ...Option) *grpc.Server {
+	var o Options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if o.Server != nil {
+		return o.Server
+	}
+
+	var serverOpts []grpc.ServerOption
+	if o.Creds != nil {
+		serverOpts = append(serverOpts, grpc.Creds(o.Creds))
+	}
+	if len(o.UnaryInterceptors) > 0 {
+		serverOpts = append(serverOpts, grpc.ChainUnaryInterceptor(o.UnaryInterceptors...))
+	}
+	if len(o.StreamInterceptors) > 0 {
+		serverOpts = append(serverOpts, grpc.ChainStreamInterceptor(o.StreamInterceptors...))
+	}
+	if o.KeepaliveParams != nil {
+		serverOpts = append(serverOpts, grpc.KeepaliveParams(*o.KeepaliveParams))
+	}
+	if o.MaxRecvMsgSize > 0 {
+		serverOpts = append(serverOpts, grpc.MaxRecvMsgSize(o.MaxRecvMsgSize))
+	}
+	if o.MaxConcurrentStreams > 0 {
+		serverOpts = append(serverOpts, grpc.MaxConcurrentStreams(o.MaxConcurrentStreams))
+	}
+
+	return grpc.NewServer(serverOpts...)
+}
+
+// CSIDriver serves the Controller, Identity and Node CSI services on a
+// single endpoint, as used by CSI plugins that don't split controller and
+// node into separate processes.
+type CSIDriver struct {
+	servers CSIDriverServers
+	server  *grpc.Server
+}
+
+// NewCSIDriver creates a CSIDriver ready to Start. By default it serves on
+// a plain grpc.NewServer(); pass Options such as WithServer or
+// WithUnaryInterceptors to customize server construction.
+func NewCSIDriver(servers *CSIDriverServers, opts ...Option) *CSIDriver {
+	return &CSIDriver{
+		servers: *servers,
+		server:  newGRPCServer(opts...),
+	}
+}
+
+// Start registers all three CSI services on l and begins serving in a
+// background goroutine.
+func (c *CSIDriver) Start(l net.Listener) error {
+	csi.RegisterIdentityServer(c.server, c.servers.Identity)
+	csi.RegisterControllerServer(c.server, c.servers.Controller)
+	csi.RegisterNodeServer(c.server, c.servers.Node)
+
+	go func() {
+		_ = c.server.Serve(l)
+	}()
+	return nil
+}
+
+// Stop gracefully stops the server, waiting for in-flight RPCs to finish.
+func (c *CSIDriver) Stop() {
+	c.server.GracefulStop()
+}
+
+// ForceStop immediately stops the server, without waiting for in-flight RPCs.
+func (c *CSIDriver) ForceStop() {
+	c.server.Stop()
+}
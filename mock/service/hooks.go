@@ -0,0 +1,98 @@
+/*
+Copyright 2018 Kubernetes Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"google.golang.org/grpc/codes"
+)
+
+// Hook describes the canned behavior to apply to a single RPC invocation.
+type Hook struct {
+	// Error, if non-empty, is the gRPC status code name (e.g. "ResourceExhausted")
+	// returned instead of calling through to the normal mock implementation.
+	Error string `yaml:"Error"`
+	// Message is returned alongside Error.
+	Message string `yaml:"Message"`
+
+	// Script, if non-empty, is evaluated per request instead of the static
+	// Error/Message above. It is a CEL expression by default, or a Starlark
+	// snippet when Lang is "starlark". See script.go for the environment
+	// exposed to it.
+	Script string `yaml:"Script"`
+	// Lang selects the script engine: "cel" (the default) or "starlark".
+	Lang string `yaml:"Lang"`
+}
+
+// Hooks is the YAML-declared, per-RPC set of canned behaviors loaded via
+// -hooks-file. It is intentionally sparse: only RPCs that test authors care
+// about need an entry.
+type Hooks struct {
+	CreateVolume *Hook `yaml:"CreateVolume"`
+	DeleteVolume *Hook `yaml:"DeleteVolume"`
+}
+
+// hookFor returns the hook configured for rpc, or nil if none was.
+func (h *Hooks) hookFor(rpc string) *Hook {
+	if h == nil {
+		return nil
+	}
+	switch rpc {
+	case "CreateVolume":
+		return h.CreateVolume
+	case "DeleteVolume":
+		return h.DeleteVolume
+	default:
+		return nil
+	}
+}
+
+// Eval looks up the hook configured for rpc and, if it has a static Error
+// configured, reports the status code and message it should fail with.
+// Hooks with a Script instead are evaluated separately by
+// Service.evalHook, since that requires access to per-service script state.
+func (h *Hooks) Eval(rpc string, req interface{}) (codes.Code, string, bool) {
+	hook := h.hookFor(rpc)
+	if hook == nil || hook.Error == "" {
+		return codes.OK, "", false
+	}
+
+	code, ok := codeByName[hook.Error]
+	if !ok {
+		code = codes.Internal
+	}
+	return code, hook.Message, true
+}
+
+var codeByName = map[string]codes.Code{
+	"OK":                 codes.OK,
+	"Canceled":           codes.Canceled,
+	"Unknown":            codes.Unknown,
+	"InvalidArgument":    codes.InvalidArgument,
+	"DeadlineExceeded":   codes.DeadlineExceeded,
+	"NotFound":           codes.NotFound,
+	"AlreadyExists":      codes.AlreadyExists,
+	"PermissionDenied":   codes.PermissionDenied,
+	"ResourceExhausted":  codes.ResourceExhausted,
+	"FailedPrecondition": codes.FailedPrecondition,
+	"Aborted":            codes.Aborted,
+	"OutOfRange":         codes.OutOfRange,
+	"Unimplemented":      codes.Unimplemented,
+	"Internal":           codes.Internal,
+	"Unavailable":        codes.Unavailable,
+	"DataLoss":           codes.DataLoss,
+	"Unauthenticated":    codes.Unauthenticated,
+}
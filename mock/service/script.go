@@ -0,0 +1,237 @@
+/*
+Copyright 2018 Kubernetes Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// ScriptState is the "state" dict exposed to hook scripts. It is mutated in
+// place across calls so a script can, e.g., fail the Nth CreateVolume for a
+// given name and succeed afterwards.
+type ScriptState struct {
+	mu   sync.Mutex
+	data map[string]interface{}
+}
+
+func newScriptState() *ScriptState {
+	return &ScriptState{data: map[string]interface{}{}}
+}
+
+func (st *ScriptState) snapshot() map[string]interface{} {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	snap := make(map[string]interface{}, len(st.data))
+	for k, v := range st.data {
+		snap[k] = v
+	}
+	return snap
+}
+
+func (st *ScriptState) merge(update map[string]interface{}) {
+	if len(update) == 0 {
+		return
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for k, v := range update {
+		st.data[k] = v
+	}
+}
+
+// scriptOutcome is what a hook script produces: either a status to fail
+// the RPC with, or a set of fields to merge into the normal response.
+type scriptOutcome struct {
+	Code     codes.Code
+	Message  string
+	Response map[string]interface{}
+	Failed   bool
+}
+
+// evalHook runs the Script configured on hook, if any, against req and the
+// service's persistent script state, and reports what the RPC should do.
+// ok is false when hook has no Script (the caller should fall back to
+// Hooks.Eval's static Error handling, or run normally).
+func (s *Service) evalHook(hook *Hook, rpc string, req proto.Message) (outcome scriptOutcome, ok bool, err error) {
+	if hook == nil || hook.Script == "" {
+		return scriptOutcome{}, false, nil
+	}
+
+	reqBytes, err := protojson.Marshal(req)
+	if err != nil {
+		return scriptOutcome{}, true, fmt.Errorf("marshaling %s request for scripting: %v", rpc, err)
+	}
+	reqMap, err := jsonToMap(reqBytes)
+	if err != nil {
+		return scriptOutcome{}, true, fmt.Errorf("decoding %s request for scripting: %v", rpc, err)
+	}
+
+	stateBefore := s.scriptState.snapshot()
+
+	switch hook.Lang {
+	case "", "cel":
+		out, newState, err := evalCEL(hook.Script, reqMap, stateBefore)
+		if err != nil {
+			return scriptOutcome{}, true, err
+		}
+		s.scriptState.merge(newState)
+		return out, true, nil
+	case "starlark":
+		out, newState, err := evalStarlark(hook.Script, reqMap, stateBefore)
+		if err != nil {
+			return scriptOutcome{}, true, err
+		}
+		s.scriptState.merge(newState)
+		return out, true, nil
+	default:
+		return scriptOutcome{}, true, fmt.Errorf("unknown hook script language %q for %s", hook.Lang, rpc)
+	}
+}
+
+// resultToOutcome interprets the map a script returned. It expects either
+// {"code": "<grpc code name>", "message": "..."} or {"response": {...}}.
+func resultToOutcome(result map[string]interface{}) scriptOutcome {
+	if codeName, ok := result["code"].(string); ok && codeName != "" && codeName != "OK" {
+		msg, _ := result["message"].(string)
+		code, ok := codeByName[codeName]
+		if !ok {
+			code = codes.Internal
+		}
+		return scriptOutcome{Code: code, Message: msg, Failed: true}
+	}
+	if resp, ok := result["response"].(map[string]interface{}); ok {
+		return scriptOutcome{Response: resp}
+	}
+	return scriptOutcome{}
+}
+
+func jsonToMap(b []byte) (map[string]interface{}, error) {
+	var m map[string]interface{}
+	if err := jsonUnmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// evalCEL compiles and evaluates expr with "req" and "state" bound to reqMap
+// and state respectively. The expression must evaluate to a map matching
+// resultToOutcome's expectations; state is not mutated by CEL itself, but
+// the expression may return a "state" key to be merged back afterwards.
+func evalCEL(expr string, reqMap, state map[string]interface{}) (scriptOutcome, map[string]interface{}, error) {
+	env, err := cel.NewEnv(
+		cel.Variable("req", cel.DynType),
+		cel.Variable("state", cel.DynType),
+	)
+	if err != nil {
+		return scriptOutcome{}, nil, fmt.Errorf("creating CEL environment: %v", err)
+	}
+
+	ast, iss := env.Compile(expr)
+	if iss != nil && iss.Err() != nil {
+		return scriptOutcome{}, nil, fmt.Errorf("compiling CEL hook script: %v", iss.Err())
+	}
+
+	prg, err := env.Program(ast)
+	if err != nil {
+		return scriptOutcome{}, nil, fmt.Errorf("building CEL program: %v", err)
+	}
+
+	out, _, err := prg.Eval(map[string]interface{}{
+		"req":   reqMap,
+		"state": state,
+	})
+	if err != nil {
+		return scriptOutcome{}, nil, fmt.Errorf("evaluating CEL hook script: %v", err)
+	}
+
+	// Converting to *structpb.Struct (rather than asserting on out.Value()
+	// or converting straight to map[string]interface{}) recursively turns
+	// every nested CEL map/list into native Go types, including nested
+	// "state" and "response" objects.
+	native, err := out.ConvertToNative(reflect.TypeOf(&structpb.Struct{}))
+	if err != nil {
+		return scriptOutcome{}, nil, fmt.Errorf("CEL hook script must return a map: %v", err)
+	}
+	st, ok := native.(*structpb.Struct)
+	if !ok {
+		return scriptOutcome{}, nil, fmt.Errorf("CEL hook script must return a map, got %T", native)
+	}
+	result := st.AsMap()
+
+	newState, _ := result["state"].(map[string]interface{})
+	return resultToOutcome(result), newState, nil
+}
+
+// evalStarlark executes script with "req" (a struct-like value) and "state"
+// (a mutable dict, seeded from and merged back into the service's
+// persistent state) predeclared, and reads the "result" global it must set.
+func evalStarlark(script string, reqMap, state map[string]interface{}) (scriptOutcome, map[string]interface{}, error) {
+	stateDict := starlark.NewDict(len(state))
+	for k, v := range state {
+		sv, err := toStarlarkValue(v)
+		if err != nil {
+			return scriptOutcome{}, nil, fmt.Errorf("converting state to Starlark: %v", err)
+		}
+		_ = stateDict.SetKey(starlark.String(k), sv)
+	}
+
+	reqVal, err := toStarlarkValue(reqMap)
+	if err != nil {
+		return scriptOutcome{}, nil, fmt.Errorf("converting request to Starlark: %v", err)
+	}
+
+	thread := &starlark.Thread{Name: "hook-script"}
+	globals, err := starlark.ExecFile(thread, "hook.star", script, starlark.StringDict{
+		"req":    reqVal,
+		"state":  stateDict,
+		"struct": starlark.NewBuiltin("struct", starlarkstruct.Make),
+	})
+	if err != nil {
+		return scriptOutcome{}, nil, fmt.Errorf("evaluating Starlark hook script: %v", err)
+	}
+
+	resultVal, ok := globals["result"]
+	if !ok {
+		return scriptOutcome{}, nil, fmt.Errorf("Starlark hook script must set a `result` global")
+	}
+	resultGo, err := fromStarlarkValue(resultVal)
+	if err != nil {
+		return scriptOutcome{}, nil, fmt.Errorf("converting Starlark result: %v", err)
+	}
+	result, ok := resultGo.(map[string]interface{})
+	if !ok {
+		return scriptOutcome{}, nil, fmt.Errorf("Starlark hook script `result` must be a dict, got %T", resultGo)
+	}
+
+	newStateGo, err := fromStarlarkValue(stateDict)
+	if err != nil {
+		return scriptOutcome{}, nil, fmt.Errorf("converting Starlark state: %v", err)
+	}
+	newState, _ := newStateGo.(map[string]interface{})
+
+	return resultToOutcome(result), newState, nil
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2018 Kubernetes Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"testing"
+
+	"google.golang.org/grpc/codes"
+)
+
+// TestEvalCELStatefulRoundTrip exercises a CEL hook script across two
+// calls, feeding the state returned by the first back into the second, the
+// way Service.evalHook does via s.scriptState. It guards against
+// out.Value() being asserted straight to map[string]interface{}, which
+// does not hold for CEL map results.
+func TestEvalCELStatefulRoundTrip(t *testing.T) {
+	const expr = `has(state.tripped) && state.tripped ?
+		{"code": "ResourceExhausted", "message": "fake full", "state": {"tripped": true}} :
+		{"response": {}, "state": {"tripped": true}}`
+
+	outcome, state, err := evalCEL(expr, map[string]interface{}{"name": "foo"}, map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("first call: %v", err)
+	}
+	if outcome.Failed {
+		t.Fatalf("first call: expected success, got failed outcome %+v", outcome)
+	}
+
+	outcome, _, err = evalCEL(expr, map[string]interface{}{"name": "foo"}, state)
+	if err != nil {
+		t.Fatalf("second call: %v", err)
+	}
+	if !outcome.Failed || outcome.Code != codes.ResourceExhausted {
+		t.Fatalf("second call: expected ResourceExhausted, got %+v", outcome)
+	}
+}
+
+// TestEvalStarlarkStatefulCounter reproduces the backlog's canonical
+// example: fail the 3rd CreateVolume for a given name with
+// ResourceExhausted, then succeed. It exercises the exact state round trip
+// (fromStarlarkValue -> merge into ScriptState -> toStarlarkValue on the
+// next call) where an int64 counter previously broke on the second call.
+func TestEvalStarlarkStatefulCounter(t *testing.T) {
+	const script = `
+def run():
+    count = (state["count"] if "count" in state else 0) + 1
+    state["count"] = count
+    if count >= 3:
+        return {"code": "ResourceExhausted", "message": "fake full"}
+    return {"response": {}}
+
+result = run()
+`
+
+	state := map[string]interface{}{}
+	for i := 1; i <= 3; i++ {
+		outcome, newState, err := evalStarlark(script, map[string]interface{}{"name": "foo"}, state)
+		if err != nil {
+			t.Fatalf("call %d: %v", i, err)
+		}
+		if i < 3 && outcome.Failed {
+			t.Fatalf("call %d: expected success, got failed outcome %+v", i, outcome)
+		}
+		if i == 3 && (!outcome.Failed || outcome.Code != codes.ResourceExhausted) {
+			t.Fatalf("call %d: expected ResourceExhausted, got %+v", i, outcome)
+		}
+		state = newState
+	}
+}
@@ -0,0 +1,439 @@
+/*
+Copyright 2018 Kubernetes Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package service implements a mock CSI plugin for use in unit and
+// integration tests of CSI sidecars and sanity suites.
+package service
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// Name is the default name of the mock CSI driver.
+	Name = "mock.csi.k8s.io"
+
+	// VendorVersion is the version reported by GetPluginInfo.
+	VendorVersion = "1.0.0"
+)
+
+// Config tunes the behavior of the mock CSI driver. It is populated from
+// command line flags in cmd/mock-driver/main.go.
+type Config struct {
+	DisableAttach              bool
+	DriverName                 string
+	AttachLimit                int64
+	NodeExpansionRequired      bool
+	EnableTopology             bool
+	DisableControllerExpansion bool
+	DisableOnlineExpansion     bool
+	PermissiveTargetPath       bool
+
+	// ExecHooks, if set, lets callers inject per-RPC behavior (a static
+	// error code and message, or a script) without recompiling the mock. It
+	// is loaded from the YAML file named by -hooks-file.
+	ExecHooks *Hooks
+
+	// Tracer, if set, receives a TraceEntry for every RPC handled by the
+	// mock driver. See -trace-file and -otlp-endpoint in cmd/mock-driver.
+	Tracer Tracer
+
+	// StateDir, if set, makes volumes survive a restart of the mock driver:
+	// they are persisted as JSON under this directory. Snapshots are not
+	// persisted: the mock's CreateSnapshot is unimplemented, and New
+	// restores only volumes. See -state-dir in cmd/mock-driver.
+	StateDir string
+}
+
+type csiVolume struct {
+	info      *csi.Volume
+	published map[string]bool // node IDs the volume is currently published to
+	staged    bool
+}
+
+// Service implements csi.ControllerServer, csi.IdentityServer and
+// csi.NodeServer backed by in-memory state, which a Store persists when
+// Config.StateDir is set. It is embedded into a
+// driver.CSIDriverServers to expose all three over gRPC.
+type Service struct {
+	sync.Mutex
+
+	config Config
+
+	// hooks holds the *Hooks currently in effect. It is stored separately
+	// from config so that SetHooks can swap it out at runtime (e.g. on
+	// SIGHUP) without a data race against in-flight RPCs reading it.
+	hooks atomic.Value
+
+	// scriptState is the "state" dict exposed to CEL/Starlark hook scripts,
+	// persisted across RPCs for the lifetime of the Service.
+	scriptState *ScriptState
+
+	// store persists volumes and snapshots so they survive a driver
+	// restart. Defaults to memStore, which persists nothing.
+	store Store
+
+	volumes map[string]*csiVolume
+}
+
+var (
+	_ csi.ControllerServer = &Service{}
+	_ csi.IdentityServer   = &Service{}
+	_ csi.NodeServer       = &Service{}
+)
+
+// New creates a mock CSI driver configured by cfg.
+func New(cfg Config) *Service {
+	if cfg.DriverName == "" {
+		cfg.DriverName = Name
+	}
+	var store Store = memStore{}
+	if cfg.StateDir != "" {
+		fs, err := NewFileStore(cfg.StateDir)
+		if err != nil {
+			klog.Errorf("Falling back to in-memory state: %v", err)
+		} else {
+			store = fs
+		}
+	}
+
+	s := &Service{
+		config:      cfg,
+		volumes:     map[string]*csiVolume{},
+		scriptState: newScriptState(),
+		store:       store,
+	}
+	s.hooks.Store(cfg.ExecHooks)
+
+	state, err := store.LoadAll()
+	if err != nil {
+		klog.Errorf("Failed to load persisted state, starting empty: %v", err)
+		state = &StoreState{}
+	}
+	for _, v := range state.Volumes {
+		s.volumes[v.VolumeId] = &csiVolume{info: v, published: map[string]bool{}}
+	}
+
+	return s
+}
+
+// Hooks returns the *Hooks currently in effect, or nil if none were
+// configured. Safe to call concurrently with SetHooks and with RPCs.
+func (s *Service) Hooks() *Hooks {
+	h, _ := s.hooks.Load().(*Hooks)
+	return h
+}
+
+// SetHooks atomically replaces the hooks in effect, e.g. after a
+// -hooks-file reload. It never blocks in-flight RPCs.
+func (s *Service) SetHooks(h *Hooks) {
+	s.hooks.Store(h)
+}
+
+func (s *Service) GetPluginInfo(ctx context.Context, req *csi.GetPluginInfoRequest) (*csi.GetPluginInfoResponse, error) {
+	outcome, hookTriggered := s.runHook("GetPluginInfo", req)
+
+	resp, err := s.traceRPC(ctx, "GetPluginInfo", req, hookTriggered, func() (proto.Message, error) {
+		if outcome.Failed {
+			return nil, status.Error(outcome.Code, outcome.Message)
+		}
+		return &csi.GetPluginInfoResponse{
+			Name:          s.config.DriverName,
+			VendorVersion: VendorVersion,
+		}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*csi.GetPluginInfoResponse), nil
+}
+
+func (s *Service) GetPluginCapabilities(ctx context.Context, req *csi.GetPluginCapabilitiesRequest) (*csi.GetPluginCapabilitiesResponse, error) {
+	outcome, hookTriggered := s.runHook("GetPluginCapabilities", req)
+
+	resp, err := s.traceRPC(ctx, "GetPluginCapabilities", req, hookTriggered, func() (proto.Message, error) {
+		if outcome.Failed {
+			return nil, status.Error(outcome.Code, outcome.Message)
+		}
+
+		caps := []*csi.PluginCapability{
+			{
+				Type: &csi.PluginCapability_Service_{
+					Service: &csi.PluginCapability_Service{
+						Type: csi.PluginCapability_Service_CONTROLLER_SERVICE,
+					},
+				},
+			},
+		}
+		if s.config.EnableTopology {
+			caps = append(caps, &csi.PluginCapability{
+				Type: &csi.PluginCapability_Service_{
+					Service: &csi.PluginCapability_Service{
+						Type: csi.PluginCapability_Service_VOLUME_ACCESSIBILITY_CONSTRAINTS,
+					},
+				},
+			})
+		}
+		return &csi.GetPluginCapabilitiesResponse{Capabilities: caps}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*csi.GetPluginCapabilitiesResponse), nil
+}
+
+func (s *Service) Probe(ctx context.Context, req *csi.ProbeRequest) (*csi.ProbeResponse, error) {
+	outcome, hookTriggered := s.runHook("Probe", req)
+
+	resp, err := s.traceRPC(ctx, "Probe", req, hookTriggered, func() (proto.Message, error) {
+		if outcome.Failed {
+			return nil, status.Error(outcome.Code, outcome.Message)
+		}
+		return &csi.ProbeResponse{}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*csi.ProbeResponse), nil
+}
+
+func (s *Service) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
+	outcome, hookTriggered := s.runHook("CreateVolume", req)
+
+	resp, err := s.traceRPC(ctx, "CreateVolume", req, hookTriggered, func() (proto.Message, error) {
+		if req.GetName() == "" {
+			return nil, status.Error(codes.InvalidArgument, "name is required")
+		}
+		if outcome.Failed {
+			return nil, status.Error(outcome.Code, outcome.Message)
+		}
+
+		s.Lock()
+		defer s.Unlock()
+
+		v, ok := s.volumes[req.GetName()]
+		if !ok {
+			capacity := int64(1) * 1024 * 1024 * 1024
+			if cr := req.GetCapacityRange(); cr != nil && cr.GetRequiredBytes() > 0 {
+				capacity = cr.GetRequiredBytes()
+			}
+
+			v = &csiVolume{
+				info: &csi.Volume{
+					VolumeId:      req.GetName(),
+					CapacityBytes: capacity,
+					VolumeContext: req.GetParameters(),
+				},
+				published: map[string]bool{},
+			}
+			s.volumes[req.GetName()] = v
+		}
+
+		// The string values of a script's "response" map are merged into
+		// VolumeContext (other values are ignored), letting a hook script
+		// simulate a driver that annotates volumes it creates.
+		for k, val := range outcome.Response {
+			if str, ok := val.(string); ok {
+				if v.info.VolumeContext == nil {
+					v.info.VolumeContext = map[string]string{}
+				}
+				v.info.VolumeContext[k] = str
+			}
+		}
+
+		if err := s.store.SaveVolume(v.info); err != nil {
+			klog.Errorf("Failed to persist volume %s: %v", v.info.VolumeId, err)
+		}
+
+		return &csi.CreateVolumeResponse{Volume: v.info}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*csi.CreateVolumeResponse), nil
+}
+
+func (s *Service) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
+	outcome, hookTriggered := s.runHook("DeleteVolume", req)
+
+	resp, err := s.traceRPC(ctx, "DeleteVolume", req, hookTriggered, func() (proto.Message, error) {
+		if outcome.Failed {
+			return nil, status.Error(outcome.Code, outcome.Message)
+		}
+
+		s.Lock()
+		defer s.Unlock()
+		delete(s.volumes, req.GetVolumeId())
+		if err := s.store.DeleteVolume(req.GetVolumeId()); err != nil {
+			klog.Errorf("Failed to remove persisted volume %s: %v", req.GetVolumeId(), err)
+		}
+		return &csi.DeleteVolumeResponse{}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*csi.DeleteVolumeResponse), nil
+}
+
+// runHook consults the configured hooks, if any, for the named RPC: a
+// static Error/Message takes effect immediately, a Script is evaluated
+// against req and the service's persistent script state. It reports the
+// outcome and whether a hook took effect; a hook whose script fails to
+// evaluate, or that sets neither Script nor Error, reports false.
+func (s *Service) runHook(rpc string, req proto.Message) (scriptOutcome, bool) {
+	hooks := s.Hooks()
+	hook := hooks.hookFor(rpc)
+	if hook == nil {
+		return scriptOutcome{}, false
+	}
+
+	if hook.Script != "" {
+		out, ok, err := s.evalHook(hook, rpc, req)
+		if err != nil {
+			klog.Errorf("Hook script for %s failed, request proceeds normally: %v", rpc, err)
+			return scriptOutcome{}, false
+		}
+		return out, ok
+	}
+
+	if code, msg, ok := hooks.Eval(rpc, req); ok {
+		return scriptOutcome{Code: code, Message: msg, Failed: true}, true
+	}
+	return scriptOutcome{}, false
+}
+
+func notImplemented(rpc string) error {
+	return status.Errorf(codes.Unimplemented, "%s is not implemented by the mock driver", rpc)
+}
+
+// traceNotImplemented records a trace entry for an unimplemented rpc before
+// returning its Unimplemented error, so a diff of trace output still shows
+// every RPC a CO called, not just the ones the mock actually implements.
+func (s *Service) traceNotImplemented(ctx context.Context, rpc string, req proto.Message) error {
+	_, err := s.traceRPC(ctx, rpc, req, false, func() (proto.Message, error) {
+		return nil, notImplemented(rpc)
+	})
+	return err
+}
+
+func (s *Service) ControllerPublishVolume(ctx context.Context, req *csi.ControllerPublishVolumeRequest) (*csi.ControllerPublishVolumeResponse, error) {
+	return nil, s.traceNotImplemented(ctx, "ControllerPublishVolume", req)
+}
+
+func (s *Service) ControllerUnpublishVolume(ctx context.Context, req *csi.ControllerUnpublishVolumeRequest) (*csi.ControllerUnpublishVolumeResponse, error) {
+	return nil, s.traceNotImplemented(ctx, "ControllerUnpublishVolume", req)
+}
+
+func (s *Service) ValidateVolumeCapabilities(ctx context.Context, req *csi.ValidateVolumeCapabilitiesRequest) (*csi.ValidateVolumeCapabilitiesResponse, error) {
+	return nil, s.traceNotImplemented(ctx, "ValidateVolumeCapabilities", req)
+}
+
+func (s *Service) ListVolumes(ctx context.Context, req *csi.ListVolumesRequest) (*csi.ListVolumesResponse, error) {
+	return nil, s.traceNotImplemented(ctx, "ListVolumes", req)
+}
+
+func (s *Service) GetCapacity(ctx context.Context, req *csi.GetCapacityRequest) (*csi.GetCapacityResponse, error) {
+	return nil, s.traceNotImplemented(ctx, "GetCapacity", req)
+}
+
+func (s *Service) ControllerGetCapabilities(ctx context.Context, req *csi.ControllerGetCapabilitiesRequest) (*csi.ControllerGetCapabilitiesResponse, error) {
+	return nil, s.traceNotImplemented(ctx, "ControllerGetCapabilities", req)
+}
+
+func (s *Service) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequest) (*csi.CreateSnapshotResponse, error) {
+	return nil, s.traceNotImplemented(ctx, "CreateSnapshot", req)
+}
+
+func (s *Service) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequest) (*csi.DeleteSnapshotResponse, error) {
+	return nil, s.traceNotImplemented(ctx, "DeleteSnapshot", req)
+}
+
+func (s *Service) ListSnapshots(ctx context.Context, req *csi.ListSnapshotsRequest) (*csi.ListSnapshotsResponse, error) {
+	return nil, s.traceNotImplemented(ctx, "ListSnapshots", req)
+}
+
+func (s *Service) ControllerExpandVolume(ctx context.Context, req *csi.ControllerExpandVolumeRequest) (*csi.ControllerExpandVolumeResponse, error) {
+	return nil, s.traceNotImplemented(ctx, "ControllerExpandVolume", req)
+}
+
+func (s *Service) ControllerGetVolume(ctx context.Context, req *csi.ControllerGetVolumeRequest) (*csi.ControllerGetVolumeResponse, error) {
+	return nil, s.traceNotImplemented(ctx, "ControllerGetVolume", req)
+}
+
+func (s *Service) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRequest) (*csi.NodeStageVolumeResponse, error) {
+	return nil, s.traceNotImplemented(ctx, "NodeStageVolume", req)
+}
+
+func (s *Service) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstageVolumeRequest) (*csi.NodeUnstageVolumeResponse, error) {
+	return nil, s.traceNotImplemented(ctx, "NodeUnstageVolume", req)
+}
+
+func (s *Service) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
+	return nil, s.traceNotImplemented(ctx, "NodePublishVolume", req)
+}
+
+func (s *Service) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
+	return nil, s.traceNotImplemented(ctx, "NodeUnpublishVolume", req)
+}
+
+func (s *Service) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVolumeStatsRequest) (*csi.NodeGetVolumeStatsResponse, error) {
+	return nil, s.traceNotImplemented(ctx, "NodeGetVolumeStats", req)
+}
+
+func (s *Service) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandVolumeRequest) (*csi.NodeExpandVolumeResponse, error) {
+	return nil, s.traceNotImplemented(ctx, "NodeExpandVolume", req)
+}
+
+func (s *Service) NodeGetCapabilities(ctx context.Context, req *csi.NodeGetCapabilitiesRequest) (*csi.NodeGetCapabilitiesResponse, error) {
+	outcome, hookTriggered := s.runHook("NodeGetCapabilities", req)
+
+	resp, err := s.traceRPC(ctx, "NodeGetCapabilities", req, hookTriggered, func() (proto.Message, error) {
+		if outcome.Failed {
+			return nil, status.Error(outcome.Code, outcome.Message)
+		}
+		return &csi.NodeGetCapabilitiesResponse{}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*csi.NodeGetCapabilitiesResponse), nil
+}
+
+func (s *Service) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
+	outcome, hookTriggered := s.runHook("NodeGetInfo", req)
+
+	resp, err := s.traceRPC(ctx, "NodeGetInfo", req, hookTriggered, func() (proto.Message, error) {
+		if outcome.Failed {
+			return nil, status.Error(outcome.Code, outcome.Message)
+		}
+		return &csi.NodeGetInfoResponse{
+			NodeId:            "mock-node",
+			MaxVolumesPerNode: s.config.AttachLimit,
+		}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*csi.NodeGetInfoResponse), nil
+}
@@ -0,0 +1,55 @@
+/*
+Copyright 2018 Kubernetes Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+)
+
+type fakeTracer struct {
+	entries []TraceEntry
+}
+
+func (t *fakeTracer) Emit(e TraceEntry) { t.entries = append(t.entries, e) }
+func (t *fakeTracer) Close() error      { return nil }
+
+// TestUnimplementedRPCsAreTraced guards against Unimplemented RPCs silently
+// disappearing from trace output, since a diff of that output is the whole
+// point of -trace-file/-otlp-endpoint.
+func TestUnimplementedRPCsAreTraced(t *testing.T) {
+	tracer := &fakeTracer{}
+	s := New(Config{Tracer: tracer})
+
+	if _, err := s.ListVolumes(context.Background(), &csi.ListVolumesRequest{}); err == nil {
+		t.Fatal("expected ListVolumes to return an error")
+	}
+
+	if len(tracer.entries) != 1 {
+		t.Fatalf("expected 1 trace entry, got %d", len(tracer.entries))
+	}
+	entry := tracer.entries[0]
+	if entry.Method != "ListVolumes" {
+		t.Fatalf("expected Method ListVolumes, got %q", entry.Method)
+	}
+	if entry.Code != codes.Unimplemented.String() {
+		t.Fatalf("expected code %s, got %s", codes.Unimplemented, entry.Code)
+	}
+}
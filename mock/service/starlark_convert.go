@@ -0,0 +1,128 @@
+/*
+Copyright 2018 Kubernetes Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"go.starlark.net/starlark"
+)
+
+func jsonUnmarshal(b []byte, v interface{}) error {
+	return json.Unmarshal(b, v)
+}
+
+// toStarlarkValue converts the plain Go values produced by encoding/json
+// (map[string]interface{}, []interface{}, string, float64, bool, nil) into
+// their Starlark equivalents.
+func toStarlarkValue(v interface{}) (starlark.Value, error) {
+	switch val := v.(type) {
+	case nil:
+		return starlark.None, nil
+	case bool:
+		return starlark.Bool(val), nil
+	case string:
+		return starlark.String(val), nil
+	case float64:
+		return starlark.Float(val), nil
+	case int:
+		return starlark.MakeInt(val), nil
+	case int32:
+		return starlark.MakeInt(int(val)), nil
+	case int64:
+		return starlark.MakeInt64(val), nil
+	case uint32:
+		return starlark.MakeUint(uint(val)), nil
+	case uint64:
+		return starlark.MakeUint64(val), nil
+	case map[string]interface{}:
+		d := starlark.NewDict(len(val))
+		for k, item := range val {
+			sv, err := toStarlarkValue(item)
+			if err != nil {
+				return nil, err
+			}
+			if err := d.SetKey(starlark.String(k), sv); err != nil {
+				return nil, err
+			}
+		}
+		return d, nil
+	case []interface{}:
+		items := make([]starlark.Value, len(val))
+		for i, item := range val {
+			sv, err := toStarlarkValue(item)
+			if err != nil {
+				return nil, err
+			}
+			items[i] = sv
+		}
+		return starlark.NewList(items), nil
+	default:
+		return nil, fmt.Errorf("unsupported type %T for Starlark conversion", v)
+	}
+}
+
+// fromStarlarkValue is the inverse of toStarlarkValue, used to read a
+// script's result and its mutated state dict back into plain Go values.
+func fromStarlarkValue(v starlark.Value) (interface{}, error) {
+	switch val := v.(type) {
+	case starlark.NoneType:
+		return nil, nil
+	case starlark.Bool:
+		return bool(val), nil
+	case starlark.String:
+		return string(val), nil
+	case starlark.Int:
+		i, ok := val.Int64()
+		if !ok {
+			return nil, fmt.Errorf("Starlark int %s overflows int64", val.String())
+		}
+		return i, nil
+	case starlark.Float:
+		return float64(val), nil
+	case *starlark.Dict:
+		m := make(map[string]interface{}, val.Len())
+		for _, item := range val.Items() {
+			key, ok := starlark.AsString(item[0])
+			if !ok {
+				return nil, fmt.Errorf("Starlark dict key %v is not a string", item[0])
+			}
+			gv, err := fromStarlarkValue(item[1])
+			if err != nil {
+				return nil, err
+			}
+			m[key] = gv
+		}
+		return m, nil
+	case *starlark.List:
+		items := make([]interface{}, 0, val.Len())
+		it := val.Iterate()
+		defer it.Done()
+		var x starlark.Value
+		for it.Next(&x) {
+			gv, err := fromStarlarkValue(x)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, gv)
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("unsupported Starlark type %s for conversion", v.Type())
+	}
+}
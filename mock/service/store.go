@@ -0,0 +1,316 @@
+/*
+Copyright 2018 Kubernetes Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	bolt "go.etcd.io/bbolt"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// StoreState is everything a Store persists. Service.New recreates its
+// in-memory volume map from Volumes after a restart; Snapshots is not read
+// by Service, which holds no snapshots.
+type StoreState struct {
+	Volumes   []*csi.Volume
+	Snapshots []*csi.Snapshot
+}
+
+// Store lets volumes and snapshots survive restarts of the mock driver,
+// e.g. for remote-integration scenarios where the driver process lives on
+// a remote host across multiple client test runs, or to test sidecar
+// recovery after a driver crash. It does not cover publish/stage state:
+// the mock's NodeStageVolume/NodePublishVolume RPCs are unimplemented, so
+// there is no such state to persist.
+type Store interface {
+	SaveVolume(v *csi.Volume) error
+	DeleteVolume(volumeID string) error
+	SaveSnapshot(s *csi.Snapshot) error
+	DeleteSnapshot(snapshotID string) error
+	LoadAll() (*StoreState, error)
+}
+
+// memStore is the historical, non-persistent behavior: state lives only in
+// the Service's own in-memory maps, so LoadAll always starts empty.
+type memStore struct{}
+
+func (memStore) SaveVolume(*csi.Volume) error     { return nil }
+func (memStore) DeleteVolume(string) error        { return nil }
+func (memStore) SaveSnapshot(*csi.Snapshot) error { return nil }
+func (memStore) DeleteSnapshot(string) error      { return nil }
+func (memStore) LoadAll() (*StoreState, error)    { return &StoreState{}, nil }
+
+// fileState is the on-disk JSON representation written by fileStore.
+type fileState struct {
+	Volumes   []json.RawMessage `json:"volumes"`
+	Snapshots []json.RawMessage `json:"snapshots"`
+}
+
+// fileStore persists state as a single JSON file under dir, rewritten
+// atomically (write to a temp file, then rename) so a crash mid-write
+// never corrupts the existing file.
+type fileStore struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewFileStore returns a Store that keeps its state in
+// filepath.Join(dir, "state.json"), creating dir if necessary.
+func NewFileStore(dir string) (Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("creating state dir %s: %v", dir, err)
+	}
+	return &fileStore{path: filepath.Join(dir, "state.json")}, nil
+}
+
+func (fs *fileStore) LoadAll() (*StoreState, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	b, err := ioutil.ReadFile(fs.path)
+	if os.IsNotExist(err) {
+		return &StoreState{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var fst fileState
+	if err := json.Unmarshal(b, &fst); err != nil {
+		return nil, fmt.Errorf("parsing state file %s: %v", fs.path, err)
+	}
+
+	state := &StoreState{}
+	for _, raw := range fst.Volumes {
+		v := &csi.Volume{}
+		if err := protojson.Unmarshal(raw, v); err != nil {
+			return nil, fmt.Errorf("parsing persisted volume: %v", err)
+		}
+		state.Volumes = append(state.Volumes, v)
+	}
+	for _, raw := range fst.Snapshots {
+		snap := &csi.Snapshot{}
+		if err := protojson.Unmarshal(raw, snap); err != nil {
+			return nil, fmt.Errorf("parsing persisted snapshot: %v", err)
+		}
+		state.Snapshots = append(state.Snapshots, snap)
+	}
+	return state, nil
+}
+
+func (fs *fileStore) SaveVolume(v *csi.Volume) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.mutate(func(fst *fileState) error {
+		raw, err := protojson.Marshal(v)
+		if err != nil {
+			return err
+		}
+		fst.Volumes = upsertRaw(fst.Volumes, volumeIDOf, v.VolumeId, raw)
+		return nil
+	})
+}
+
+func (fs *fileStore) DeleteVolume(volumeID string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.mutate(func(fst *fileState) error {
+		fst.Volumes = deleteRaw(fst.Volumes, volumeIDOf, volumeID)
+		return nil
+	})
+}
+
+func (fs *fileStore) SaveSnapshot(s *csi.Snapshot) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.mutate(func(fst *fileState) error {
+		raw, err := protojson.Marshal(s)
+		if err != nil {
+			return err
+		}
+		fst.Snapshots = upsertRaw(fst.Snapshots, snapshotIDOf, s.SnapshotId, raw)
+		return nil
+	})
+}
+
+func (fs *fileStore) DeleteSnapshot(snapshotID string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.mutate(func(fst *fileState) error {
+		fst.Snapshots = deleteRaw(fst.Snapshots, snapshotIDOf, snapshotID)
+		return nil
+	})
+}
+
+// mutate reads the current file state (an empty one if the file doesn't
+// exist yet), applies fn, and atomically rewrites the file. The caller
+// must hold fs.mu.
+func (fs *fileStore) mutate(fn func(*fileState) error) error {
+	var fst fileState
+	if b, err := ioutil.ReadFile(fs.path); err == nil {
+		if err := json.Unmarshal(b, &fst); err != nil {
+			return fmt.Errorf("parsing state file %s: %v", fs.path, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := fn(&fst); err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(fst)
+	if err != nil {
+		return err
+	}
+
+	tmp := fs.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, fs.path)
+}
+
+func volumeIDOf(raw json.RawMessage) string {
+	v := &csi.Volume{}
+	_ = protojson.Unmarshal(raw, v)
+	return v.VolumeId
+}
+
+func snapshotIDOf(raw json.RawMessage) string {
+	s := &csi.Snapshot{}
+	_ = protojson.Unmarshal(raw, s)
+	return s.SnapshotId
+}
+
+func upsertRaw(items []json.RawMessage, idOf func(json.RawMessage) string, id string, raw json.RawMessage) []json.RawMessage {
+	for i, item := range items {
+		if idOf(item) == id {
+			items[i] = raw
+			return items
+		}
+	}
+	return append(items, raw)
+}
+
+func deleteRaw(items []json.RawMessage, idOf func(json.RawMessage) string, id string) []json.RawMessage {
+	out := items[:0]
+	for _, item := range items {
+		if idOf(item) != id {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// boltBucketVolumes and boltBucketSnapshots are the bbolt buckets used by
+// boltStore.
+var (
+	boltBucketVolumes   = []byte("volumes")
+	boltBucketSnapshots = []byte("snapshots")
+)
+
+// boltStore persists state in a single BoltDB file, trading fileStore's
+// simplicity for crash-safe, indexed per-key writes instead of a full
+// rewrite on every call.
+type boltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore opens (creating if necessary) a BoltDB file at path.
+func NewBoltStore(path string) (Store, error) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, fmt.Errorf("opening bolt state file %s: %v", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(boltBucketVolumes); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(boltBucketSnapshots)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &boltStore{db: db}, nil
+}
+
+func (bs *boltStore) LoadAll() (*StoreState, error) {
+	state := &StoreState{}
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(boltBucketVolumes).ForEach(func(_, raw []byte) error {
+			v := &csi.Volume{}
+			if err := protojson.Unmarshal(raw, v); err != nil {
+				return err
+			}
+			state.Volumes = append(state.Volumes, v)
+			return nil
+		}); err != nil {
+			return err
+		}
+		return tx.Bucket(boltBucketSnapshots).ForEach(func(_, raw []byte) error {
+			s := &csi.Snapshot{}
+			if err := protojson.Unmarshal(raw, s); err != nil {
+				return err
+			}
+			state.Snapshots = append(state.Snapshots, s)
+			return nil
+		})
+	})
+	return state, err
+}
+
+func (bs *boltStore) SaveVolume(v *csi.Volume) error {
+	raw, err := protojson.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucketVolumes).Put([]byte(v.VolumeId), raw)
+	})
+}
+
+func (bs *boltStore) DeleteVolume(volumeID string) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucketVolumes).Delete([]byte(volumeID))
+	})
+}
+
+func (bs *boltStore) SaveSnapshot(s *csi.Snapshot) error {
+	raw, err := protojson.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucketSnapshots).Put([]byte(s.SnapshotId), raw)
+	})
+}
+
+func (bs *boltStore) DeleteSnapshot(snapshotID string) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucketSnapshots).Delete([]byte(snapshotID))
+	})
+}
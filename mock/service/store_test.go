@@ -0,0 +1,123 @@
+/*
+Copyright 2018 Kubernetes Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+func TestFileStoreSaveLoadAll(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+
+	if err := store.SaveVolume(&csi.Volume{VolumeId: "vol-1", CapacityBytes: 1024}); err != nil {
+		t.Fatalf("SaveVolume: %v", err)
+	}
+	if err := store.SaveVolume(&csi.Volume{VolumeId: "vol-2", CapacityBytes: 2048}); err != nil {
+		t.Fatalf("SaveVolume: %v", err)
+	}
+	if err := store.SaveSnapshot(&csi.Snapshot{SnapshotId: "snap-1", SourceVolumeId: "vol-1"}); err != nil {
+		t.Fatalf("SaveSnapshot: %v", err)
+	}
+
+	state, err := store.LoadAll()
+	if err != nil {
+		t.Fatalf("LoadAll: %v", err)
+	}
+	if len(state.Volumes) != 2 {
+		t.Fatalf("expected 2 volumes, got %d", len(state.Volumes))
+	}
+	if len(state.Snapshots) != 1 {
+		t.Fatalf("expected 1 snapshot, got %d", len(state.Snapshots))
+	}
+
+	if err := store.DeleteVolume("vol-1"); err != nil {
+		t.Fatalf("DeleteVolume: %v", err)
+	}
+
+	// A second store opened against the same directory must see the
+	// persisted state, not just the in-process copy.
+	reopened, err := NewFileStore(filepath.Dir(store.(*fileStore).path))
+	if err != nil {
+		t.Fatalf("NewFileStore (reopen): %v", err)
+	}
+	state, err = reopened.LoadAll()
+	if err != nil {
+		t.Fatalf("LoadAll (reopen): %v", err)
+	}
+	if len(state.Volumes) != 1 || state.Volumes[0].VolumeId != "vol-2" {
+		t.Fatalf("expected only vol-2 to survive delete, got %+v", state.Volumes)
+	}
+	if len(state.Snapshots) != 1 || state.Snapshots[0].SnapshotId != "snap-1" {
+		t.Fatalf("expected snap-1 to persist, got %+v", state.Snapshots)
+	}
+}
+
+func TestFileStoreLoadAllMissingFile(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	state, err := store.LoadAll()
+	if err != nil {
+		t.Fatalf("LoadAll: %v", err)
+	}
+	if len(state.Volumes) != 0 || len(state.Snapshots) != 0 {
+		t.Fatalf("expected empty state before any save, got %+v", state)
+	}
+}
+
+func TestBoltStoreSaveLoadAll(t *testing.T) {
+	store, err := NewBoltStore(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatalf("NewBoltStore: %v", err)
+	}
+
+	if err := store.SaveVolume(&csi.Volume{VolumeId: "vol-1", CapacityBytes: 1024}); err != nil {
+		t.Fatalf("SaveVolume: %v", err)
+	}
+	if err := store.SaveSnapshot(&csi.Snapshot{SnapshotId: "snap-1", SourceVolumeId: "vol-1"}); err != nil {
+		t.Fatalf("SaveSnapshot: %v", err)
+	}
+
+	state, err := store.LoadAll()
+	if err != nil {
+		t.Fatalf("LoadAll: %v", err)
+	}
+	if len(state.Volumes) != 1 || state.Volumes[0].VolumeId != "vol-1" {
+		t.Fatalf("expected vol-1, got %+v", state.Volumes)
+	}
+	if len(state.Snapshots) != 1 || state.Snapshots[0].SnapshotId != "snap-1" {
+		t.Fatalf("expected snap-1, got %+v", state.Snapshots)
+	}
+
+	if err := store.DeleteVolume("vol-1"); err != nil {
+		t.Fatalf("DeleteVolume: %v", err)
+	}
+	state, err = store.LoadAll()
+	if err != nil {
+		t.Fatalf("LoadAll after delete: %v", err)
+	}
+	if len(state.Volumes) != 0 {
+		t.Fatalf("expected vol-1 to be gone, got %+v", state.Volumes)
+	}
+}
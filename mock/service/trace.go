@@ -0,0 +1,198 @@
+/*
+Copyright 2018 Kubernetes Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/kubernetes-csi/csi-lib-utils/protosanitizer"
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// TraceSchemaVersion is bumped whenever the shape of TraceEntry changes in
+// a way that breaks consumers that diff trace output across sanity runs.
+const TraceSchemaVersion = 1
+
+// TraceEntry records a single CSI RPC invocation. Fields are exported so
+// that it round-trips cleanly through encoding/json.
+type TraceEntry struct {
+	SchemaVersion int       `json:"schemaVersion"`
+	Method        string    `json:"method"`
+	Timestamp     time.Time `json:"timestamp"`
+	LatencyMillis int64     `json:"latencyMillis"`
+	Request       string    `json:"request"`
+	Response      string    `json:"response,omitempty"`
+	Code          string    `json:"code"`
+	Error         string    `json:"error,omitempty"`
+	HookTriggered bool      `json:"hookTriggered"`
+}
+
+// Tracer receives a TraceEntry for every RPC the mock driver handles. It
+// must be safe for concurrent use.
+type Tracer interface {
+	Emit(TraceEntry)
+	Close() error
+}
+
+// traceRPC wraps the invocation of an RPC method for tracing purposes. call
+// performs the actual work; rpc is used only for the Method field of the
+// resulting TraceEntry, whose Code is derived from the error call returns.
+func (s *Service) traceRPC(ctx context.Context, rpc string, req proto.Message, hookTriggered bool, call func() (proto.Message, error)) (proto.Message, error) {
+	if s.config.Tracer == nil {
+		return call()
+	}
+
+	start := time.Now()
+	resp, err := call()
+	entry := TraceEntry{
+		SchemaVersion: TraceSchemaVersion,
+		Method:        rpc,
+		Timestamp:     start,
+		LatencyMillis: time.Since(start).Milliseconds(),
+		Request:       protosanitizer.StripSecrets(req).String(),
+		Code:          status.Code(err).String(),
+		HookTriggered: hookTriggered,
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	} else if resp != nil {
+		entry.Response = protosanitizer.StripSecrets(resp).String()
+	}
+	s.config.Tracer.Emit(entry)
+
+	return resp, err
+}
+
+// fileTracer writes newline-delimited JSON trace entries to a file. Writes
+// are serialized so concurrent RPCs don't interleave partial lines.
+type fileTracer struct {
+	mu  sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+}
+
+// NewFileTracer opens (creating if necessary) path for append and returns a
+// Tracer that writes one JSON object per line to it.
+func NewFileTracer(path string) (Tracer, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open trace file %s: %v", path, err)
+	}
+	return &fileTracer{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+func (t *fileTracer) Emit(e TraceEntry) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// A failed trace write must never take down the mock driver: logging
+	// the request/response history is diagnostic, not load-bearing.
+	_ = t.enc.Encode(e)
+}
+
+func (t *fileTracer) Close() error {
+	return t.f.Close()
+}
+
+// otlpTracer forwards trace entries to an OTLP/gRPC log collector, one
+// export call per RPC. It is deliberately synchronous and best-effort: a
+// collector outage degrades to dropped traces, not failed CSI calls.
+type otlpTracer struct {
+	conn   *grpc.ClientConn
+	client collogspb.LogsServiceClient
+}
+
+// NewOTLPTracer dials endpoint (an OTLP/gRPC collector address, e.g.
+// "localhost:4317") and returns a Tracer that exports every entry as an
+// OTLP log record.
+func NewOTLPTracer(endpoint string) (Tracer, error) {
+	conn, err := grpc.Dial(endpoint, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial OTLP endpoint %s: %v", endpoint, err)
+	}
+	return &otlpTracer{conn: conn, client: collogspb.NewLogsServiceClient(conn)}, nil
+}
+
+func (t *otlpTracer) Emit(e TraceEntry) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+
+	record := &logspb.LogRecord{
+		TimeUnixNano: uint64(e.Timestamp.UnixNano()),
+		Body:         &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: string(body)}},
+		Attributes: []*commonpb.KeyValue{
+			{Key: "csi.method", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: e.Method}}},
+			{Key: "csi.code", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: e.Code}}},
+		},
+	}
+
+	req := &collogspb.ExportLogsServiceRequest{
+		ResourceLogs: []*logspb.ResourceLogs{
+			{
+				Resource: &resourcepb.Resource{
+					Attributes: []*commonpb.KeyValue{
+						{Key: "service.name", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: "csi-mock-driver"}}},
+					},
+				},
+				ScopeLogs: []*logspb.ScopeLogs{
+					{LogRecords: []*logspb.LogRecord{record}},
+				},
+			},
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	// Best-effort: a failed export must never take down the mock driver.
+	_, _ = t.client.Export(ctx, req)
+}
+
+func (t *otlpTracer) Close() error {
+	return t.conn.Close()
+}
+
+// MultiTracer fans a single TraceEntry out to every tracer in ts.
+type MultiTracer []Tracer
+
+func (m MultiTracer) Emit(e TraceEntry) {
+	for _, t := range m {
+		t.Emit(e)
+	}
+}
+
+func (m MultiTracer) Close() error {
+	var err error
+	for _, t := range m {
+		if cerr := t.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}